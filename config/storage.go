@@ -0,0 +1,60 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+// Package config holds the configuration structures shared by chihaya's
+// storage drivers.
+package config
+
+import "time"
+
+// Duration wraps time.Duration so it can be unmarshaled from the
+// human-readable strings ("30s", "5m") used in the tracker's config
+// file.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Storage configures a storage driver: which driver to use, its
+// connection parameters, and the behavior of its background reaper.
+type Storage struct {
+	Driver string
+
+	Network     string
+	Addr        string
+	Prefix      string
+	MaxIdleConn int
+	IdleTimeout Duration
+	ConnTimeout *Duration
+	Cluster     bool
+
+	AnnounceInterval Duration
+	ReapInterval     Duration
+	Inactivity       Duration
+
+	// TxRetries bounds how many times a WATCH-based transaction retries
+	// after losing a race on a watched key before giving up with
+	// storage.ErrTxRetriesExceeded.
+	TxRetries int
+
+	// PublishChannel is the Redis pub/sub channel mutations are announced
+	// on. Publishing is disabled when this is empty.
+	PublishChannel string
+	// PublishEvents whitelists which event types get published. An empty
+	// list whitelists everything.
+	PublishEvents []string
+}
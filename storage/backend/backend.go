@@ -0,0 +1,68 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+// Package backend defines the interface for the tracker's durable
+// user/torrent/whitelist metadata store. Cache drivers (see
+// storage/cache) enqueue deltas here instead of writing durable fields
+// like snatch counts and transfer totals synchronously on every
+// announce.
+package backend
+
+import (
+	"context"
+
+	"github.com/pushrax/chihaya/config"
+	"github.com/pushrax/chihaya/storage"
+)
+
+// Change describes a durable-field delta produced by a cache mutation
+// that a backend driver must eventually persist.
+type Change struct {
+	Kind     string // "snatch", "uploaded", "downloaded", "left"
+	Infohash string
+	Passkey  string
+	Delta    int64
+}
+
+// Conn represents a connection to the durable metadata store.
+type Conn interface {
+	PutUser(ctx context.Context, u *storage.User) error
+	DeleteUser(ctx context.Context, passkey string) error
+	PutTorrent(ctx context.Context, t *storage.Torrent) error
+	DeleteTorrent(ctx context.Context, infohash string) error
+	PutClient(ctx context.Context, peerID string) error
+	DeleteClient(ctx context.Context, peerID string) error
+
+	// Apply persists a single queued Change drained from a cache driver.
+	Apply(ctx context.Context, change Change) error
+}
+
+// Driver is the interface backend storage drivers must implement to be
+// usable by chihaya.
+type Driver interface {
+	New(conf *config.Storage) Conn
+}
+
+var drivers = make(map[string]Driver)
+
+// Register makes a backend driver available under name. If Register is
+// called twice with the same name, it panics.
+func Register(name string, d Driver) {
+	if d == nil {
+		panic("backend: nil driver registered")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("backend: duplicate driver registered: " + name)
+	}
+	drivers[name] = d
+}
+
+// New creates a backend connection specified by a configuration.
+func New(conf *config.Storage) Conn {
+	driver, ok := drivers[conf.Driver]
+	if !ok {
+		panic("backend: unknown driver: " + conf.Driver)
+	}
+	return driver.New(conf)
+}
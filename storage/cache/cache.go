@@ -0,0 +1,71 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+// Package cache defines the interface for the tracker's hot, ephemeral
+// swarm store: peers, snatch counters, and announce timestamps. Durable
+// user/torrent/whitelist metadata lives behind the storage/backend
+// package instead; cache drivers may enqueue deltas for it to persist
+// asynchronously. Lookups are served from the cache alone: a miss is not
+// forwarded to the backend.
+package cache
+
+import (
+	"context"
+
+	"github.com/pushrax/chihaya/config"
+	"github.com/pushrax/chihaya/storage"
+)
+
+// Conn represents a connection to the swarm cache. Mutating methods handle
+// concurrency internally and are safe to call concurrently for the same
+// torrent or user, rather than requiring callers to manage a transaction's
+// lifecycle. Every
+// method takes a context so an announce handler can bind its Redis calls
+// to the HTTP request's deadline.
+type Conn interface {
+	FindUser(ctx context.Context, passkey string) (*storage.User, bool, error)
+	FindTorrent(ctx context.Context, infohash string) (*storage.Torrent, bool, error)
+	ClientWhitelisted(ctx context.Context, peerID string) (bool, error)
+
+	// Snatch records that u completed t: it atomically moves p from t's
+	// leecher swarm to its seeder swarm, bumps both their Snatches
+	// tallies, and stamps t's Completed time.
+	Snatch(ctx context.Context, u *storage.User, t *storage.Torrent, p *storage.Peer) error
+	Unprune(ctx context.Context, t *storage.Torrent) error
+	NewLeecher(ctx context.Context, t *storage.Torrent, p *storage.Peer) error
+	RmLeecher(ctx context.Context, t *storage.Torrent, p *storage.Peer) error
+	NewSeeder(ctx context.Context, t *storage.Torrent, p *storage.Peer) error
+	RmSeeder(ctx context.Context, t *storage.Torrent, p *storage.Peer) error
+	IncrementSlots(ctx context.Context, u *storage.User) error
+	DecrementSlots(ctx context.Context, u *storage.User) error
+}
+
+// Driver is the interface cache storage drivers must implement to be
+// usable by chihaya.
+type Driver interface {
+	New(conf *config.Storage) Conn
+}
+
+var drivers = make(map[string]Driver)
+
+// Register makes a cache driver available under name. If Register is
+// called twice with the same name, it panics.
+func Register(name string, d Driver) {
+	if d == nil {
+		panic("cache: nil driver registered")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("cache: duplicate driver registered: " + name)
+	}
+	drivers[name] = d
+}
+
+// New creates a cache connection specified by a configuration.
+func New(conf *config.Storage) Conn {
+	driver, ok := drivers[conf.Driver]
+	if !ok {
+		panic("cache: unknown driver: " + conf.Driver)
+	}
+	return driver.New(conf)
+}
@@ -0,0 +1,260 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+// Package memory implements the cache interface for a BitTorrent tracker
+// with in-process, sync.RWMutex-guarded maps. It requires no external
+// service, which makes it useful for tests and for running the tracker
+// standalone.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pushrax/chihaya/config"
+	"github.com/pushrax/chihaya/storage"
+	"github.com/pushrax/chihaya/storage/cache"
+)
+
+type driver struct{}
+
+func (d *driver) New(conf *config.Storage) cache.Conn {
+	return &DS{
+		users:     make(map[string]*storage.User),
+		torrents:  make(map[string]*storage.Torrent),
+		whitelist: make(map[string]bool),
+		leechers:  make(map[string]map[string]*storage.Peer),
+		seeders:   make(map[string]map[string]*storage.Peer),
+	}
+}
+
+// DS is an in-memory cache.Conn. All of its state lives in process
+// memory behind mu, and is lost when the process exits.
+type DS struct {
+	mu sync.RWMutex
+
+	users     map[string]*storage.User    // keyed by passkey
+	torrents  map[string]*storage.Torrent // keyed by infohash
+	whitelist map[string]bool             // keyed by peer ID
+
+	leechers map[string]map[string]*storage.Peer // infohash -> peer ID -> peer
+	seeders  map[string]map[string]*storage.Peer
+}
+
+func (ds *DS) FindUser(ctx context.Context, passkey string) (*storage.User, bool, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	user, ok := ds.users[passkey]
+	if !ok {
+		return nil, false, nil
+	}
+	clone := *user
+	return &clone, true, nil
+}
+
+func (ds *DS) FindTorrent(ctx context.Context, infohash string) (*storage.Torrent, bool, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	torrent, ok := ds.torrents[infohash]
+	if !ok {
+		return nil, false, nil
+	}
+	clone := *torrent
+	return &clone, true, nil
+}
+
+func (ds *DS) ClientWhitelisted(ctx context.Context, peerID string) (bool, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	return ds.whitelist[peerID], nil
+}
+
+// PutUser creates or overwrites the user record at u.Passkey.
+func (ds *DS) PutUser(ctx context.Context, u *storage.User) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	clone := *u
+	ds.users[u.Passkey] = &clone
+	return nil
+}
+
+// DeleteUser removes the user record identified by passkey.
+func (ds *DS) DeleteUser(ctx context.Context, passkey string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	delete(ds.users, passkey)
+	return nil
+}
+
+// PutTorrent creates or overwrites the torrent record at t.Infohash.
+func (ds *DS) PutTorrent(ctx context.Context, t *storage.Torrent) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	clone := *t
+	ds.torrents[t.Infohash] = &clone
+	return nil
+}
+
+// DeleteTorrent removes the torrent record and its swarms identified by
+// infohash.
+func (ds *DS) DeleteTorrent(ctx context.Context, infohash string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	delete(ds.torrents, infohash)
+	delete(ds.leechers, infohash)
+	delete(ds.seeders, infohash)
+	return nil
+}
+
+// PutClient whitelists peerID.
+func (ds *DS) PutClient(ctx context.Context, peerID string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.whitelist[peerID] = true
+	return nil
+}
+
+// DeleteClient removes peerID from the whitelist.
+func (ds *DS) DeleteClient(ctx context.Context, peerID string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	delete(ds.whitelist, peerID)
+	return nil
+}
+
+// Snatch records a completed download: it moves peer from torrent's
+// leecher swarm to its seeder swarm and bumps both the torrent's and
+// the user's Snatches tallies.
+func (ds *DS) Snatch(ctx context.Context, user *storage.User, torrent *storage.Torrent, peer *storage.Peer) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if peers, ok := ds.leechers[torrent.Infohash]; ok {
+		if _, existed := peers[peer.ID]; existed {
+			delete(peers, peer.ID)
+			if t, ok := ds.torrents[torrent.Infohash]; ok {
+				t.Leechers--
+			}
+		}
+	}
+	if t, ok := ds.torrents[torrent.Infohash]; ok {
+		seeders, ok := ds.seeders[torrent.Infohash]
+		if !ok {
+			seeders = make(map[string]*storage.Peer)
+			ds.seeders[torrent.Infohash] = seeders
+		}
+		if _, existed := seeders[peer.ID]; !existed {
+			t.Seeders++
+		}
+		clone := *peer
+		seeders[peer.ID] = &clone
+
+		t.Snatches++
+		t.Completed = time.Now().Unix()
+	}
+	if u, ok := ds.users[user.Passkey]; ok {
+		u.Snatches++
+	}
+	return nil
+}
+
+func (ds *DS) Unprune(ctx context.Context, t *storage.Torrent) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if torrent, ok := ds.torrents[t.Infohash]; ok {
+		torrent.Status = 0
+	}
+	return nil
+}
+
+func (ds *DS) NewLeecher(ctx context.Context, t *storage.Torrent, p *storage.Peer) error {
+	return ds.addPeer(t, p, ds.leechers, func(torrent *storage.Torrent, delta int) { torrent.Leechers += delta })
+}
+
+func (ds *DS) RmLeecher(ctx context.Context, t *storage.Torrent, p *storage.Peer) error {
+	return ds.rmPeer(t, p, ds.leechers, func(torrent *storage.Torrent, delta int) { torrent.Leechers += delta })
+}
+
+func (ds *DS) NewSeeder(ctx context.Context, t *storage.Torrent, p *storage.Peer) error {
+	return ds.addPeer(t, p, ds.seeders, func(torrent *storage.Torrent, delta int) { torrent.Seeders += delta })
+}
+
+func (ds *DS) RmSeeder(ctx context.Context, t *storage.Torrent, p *storage.Peer) error {
+	return ds.rmPeer(t, p, ds.seeders, func(torrent *storage.Torrent, delta int) { torrent.Seeders += delta })
+}
+
+func (ds *DS) addPeer(t *storage.Torrent, p *storage.Peer, swarm map[string]map[string]*storage.Peer, adjustCount func(*storage.Torrent, int)) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	peers, ok := swarm[t.Infohash]
+	if !ok {
+		peers = make(map[string]*storage.Peer)
+		swarm[t.Infohash] = peers
+	}
+	_, existed := peers[p.ID]
+	clone := *p
+	peers[p.ID] = &clone
+
+	if !existed {
+		if torrent, ok := ds.torrents[t.Infohash]; ok {
+			adjustCount(torrent, 1)
+		}
+	}
+	return nil
+}
+
+func (ds *DS) rmPeer(t *storage.Torrent, p *storage.Peer, swarm map[string]map[string]*storage.Peer, adjustCount func(*storage.Torrent, int)) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	peers, ok := swarm[t.Infohash]
+	if !ok {
+		return nil
+	}
+	if _, existed := peers[p.ID]; !existed {
+		return nil
+	}
+	delete(peers, p.ID)
+
+	if torrent, ok := ds.torrents[t.Infohash]; ok {
+		adjustCount(torrent, -1)
+	}
+	return nil
+}
+
+func (ds *DS) IncrementSlots(ctx context.Context, u *storage.User) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if user, ok := ds.users[u.Passkey]; ok {
+		user.Slots++
+	}
+	return nil
+}
+
+func (ds *DS) DecrementSlots(ctx context.Context, u *storage.User) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if user, ok := ds.users[u.Passkey]; ok {
+		user.Slots--
+	}
+	return nil
+}
+
+func init() {
+	cache.Register("memory", &driver{})
+}
@@ -0,0 +1,18 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package memory
+
+import (
+	"testing"
+
+	"github.com/pushrax/chihaya/config"
+	"github.com/pushrax/chihaya/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	d := &driver{}
+	ds := d.New(&config.Storage{}).(*DS)
+	storagetest.Suite(t, ds, ds)
+}
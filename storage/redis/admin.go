@@ -0,0 +1,84 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package redis
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pushrax/chihaya/storage"
+)
+
+// AdminHandler serves a small JSON API that lets web-app integrations
+// push user, torrent, and whitelist metadata into the store without
+// out-of-band Redis writes. Every request must carry an
+// "Authorization: Bearer <Secret>" header matching Secret, since this
+// API grants unrestricted write and delete access to every user, torrent,
+// and whitelist record.
+type AdminHandler struct {
+	DS     *DS
+	Secret string
+}
+
+// authorized reports whether r carries a bearer token matching
+// h.Secret. It uses a constant-time comparison so responding to a
+// guessed prefix can't leak how much of the secret the guess got right.
+func (h *AdminHandler) authorized(r *http.Request) bool {
+	if h.Secret == "" {
+		// An unconfigured secret must fail closed, not grant access to
+		// whoever sends an empty bearer token.
+		return false
+	}
+
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	token := auth[len(prefix):]
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.Secret)) == 1
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var err error
+	ctx := r.Context()
+
+	switch {
+	case r.URL.Path == "/users" && r.Method == "PUT":
+		user := &storage.User{}
+		if err = json.NewDecoder(r.Body).Decode(user); err == nil {
+			err = h.DS.PutUser(ctx, user)
+		}
+	case r.URL.Path == "/users" && r.Method == "DELETE":
+		err = h.DS.DeleteUser(ctx, r.URL.Query().Get("passkey"))
+	case r.URL.Path == "/torrents" && r.Method == "PUT":
+		torrent := &storage.Torrent{}
+		if err = json.NewDecoder(r.Body).Decode(torrent); err == nil {
+			err = h.DS.PutTorrent(ctx, torrent)
+		}
+	case r.URL.Path == "/torrents" && r.Method == "DELETE":
+		err = h.DS.DeleteTorrent(ctx, r.URL.Query().Get("infohash"))
+	case r.URL.Path == "/clients" && r.Method == "PUT":
+		err = h.DS.PutClient(ctx, r.URL.Query().Get("peer_id"))
+	case r.URL.Path == "/clients" && r.Method == "DELETE":
+		err = h.DS.DeleteClient(ctx, r.URL.Query().Get("peer_id"))
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,98 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Event is the envelope published to PublishChannel whenever a swarm,
+// snatch, unprune, or slot mutation on DS succeeds, so a web-app
+// integration can follow tracker state without polling Redis directly.
+// The Put/Delete admin methods publish no events.
+type Event struct {
+	Type      string `json:"type"`
+	Infohash  string `json:"infohash"`
+	UserID    string `json:"user_id,omitempty"`
+	PeerID    string `json:"peer_id,omitempty"`
+	Delta     int64  `json:"delta,omitempty"`
+	Timestamp int64  `json:"ts"`
+}
+
+// publish announces ev on conf.PublishChannel if conf.PublishEvents
+// whitelists ev.Type. A disabled or unwhitelisted event is a silent
+// no-op so callers never need to check whether publishing is enabled.
+func (ds *DS) publish(ctx context.Context, ev Event) {
+	if ds.conf.PublishChannel == "" || !ds.eventWhitelisted(ev.Type) {
+		return
+	}
+
+	ev.Timestamp = time.Now().Unix()
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Println("redis: failed to marshal event:", err)
+		return
+	}
+
+	if err := ds.client.Publish(ctx, ds.conf.PublishChannel, body).Err(); err != nil {
+		log.Println("redis: failed to publish event:", err)
+	}
+}
+
+func (ds *DS) eventWhitelisted(kind string) bool {
+	if len(ds.conf.PublishEvents) == 0 {
+		return true
+	}
+	for _, allowed := range ds.conf.PublishEvents {
+		if allowed == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe returns a channel of Events published on the given Redis
+// pub/sub channels. The channel is closed, and the underlying
+// subscription released, when ctx is canceled.
+func (ds *DS) Subscribe(ctx context.Context, channels ...string) (<-chan Event, error) {
+	pubsub := ds.client.Subscribe(ctx, channels...)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var ev Event
+				if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+					log.Println("redis: failed to unmarshal event:", err)
+					continue
+				}
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
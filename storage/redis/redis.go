@@ -2,241 +2,525 @@
 // Use of this source code is governed by the BSD 2-Clause license,
 // which can be found in the LICENSE file.
 
-// Package redis implements the storage interface for a BitTorrent tracker.
+// Package redis implements the cache interface for a BitTorrent tracker,
+// and additionally offers Put/Delete methods over the same data that a
+// backend driver can use to persist durable changes it drains from the
+// change queue.
 package redis
 
 import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/garyburd/redigo/redis"
+	goredis "github.com/redis/go-redis/v9"
 
 	"github.com/pushrax/chihaya/config"
 	"github.com/pushrax/chihaya/storage"
+	"github.com/pushrax/chihaya/storage/backend"
+	"github.com/pushrax/chihaya/storage/cache"
 )
 
+// reapInterval is how often the reaper scans swarms for stale peers when
+// the config doesn't override it.
+const reapInterval = time.Minute
+
+// changeQueueSize is how many durable-field deltas may be buffered
+// between the cache and a backend driver before Snatch starts blocking
+// its callers on the enqueue.
+const changeQueueSize = 4096
+
+// applyTimeout bounds how long drainChanges waits for a single dequeued
+// Change to apply, so a stalled backend can't wedge the drain loop
+// (and, in turn, the bounded change queue) forever.
+const applyTimeout = 10 * time.Second
+
 type driver struct{}
 
-func (d *driver) New(conf *config.Storage) storage.DS {
-	return &DS{
-		conf: conf,
-		Pool: redis.Pool{
-			MaxIdle:      conf.MaxIdleConn,
-			IdleTimeout:  conf.IdleTimeout.Duration,
-			Dial:         makeDialFunc(conf),
-			TestOnBorrow: testOnBorrow,
-		},
-	}
-}
-
-func makeDialFunc(conf *config.Storage) func() (redis.Conn, error) {
-	return func() (redis.Conn, error) {
-		var (
-			conn redis.Conn
-			err  error
-		)
-
-		if conf.ConnTimeout != nil {
-			conn, err = redis.DialTimeout(
-				conf.Network,
-				conf.Addr,
-				conf.ConnTimeout.Duration, // Connect Timeout
-				conf.ConnTimeout.Duration, // Read Timeout
-				conf.ConnTimeout.Duration, // Write Timeout
-			)
-		} else {
-			conn, err = redis.Dial(conf.Network, conf.Addr)
-		}
-		if err != nil {
-			return nil, err
-		}
-		return conn, nil
+func (d *driver) New(conf *config.Storage) cache.Conn {
+	ds := &DS{
+		conf:    conf,
+		client:  newClient(conf),
+		changes: make(chan backend.Change, changeQueueSize),
 	}
+	go ds.reap()
+	go ds.drainChanges()
+	return ds
 }
 
-func testOnBorrow(c redis.Conn, t time.Time) error {
-	_, err := c.Do("PING")
-	return err
+// newClient builds a go-redis client or cluster client depending on
+// conf.Cluster, mapping the pool knobs this driver has always exposed
+// onto their go-redis equivalents.
+func newClient(conf *config.Storage) goredis.UniversalClient {
+	var dialTimeout time.Duration
+	if conf.ConnTimeout != nil {
+		dialTimeout = conf.ConnTimeout.Duration
+	}
+
+	if conf.Cluster {
+		return goredis.NewClusterClient(&goredis.ClusterOptions{
+			Addrs:           []string{conf.Addr},
+			PoolSize:        conf.MaxIdleConn,
+			ConnMaxIdleTime: conf.IdleTimeout.Duration,
+			DialTimeout:     dialTimeout,
+			ReadTimeout:     dialTimeout,
+			WriteTimeout:    dialTimeout,
+		})
+	}
+	return goredis.NewClient(&goredis.Options{
+		Network:         conf.Network,
+		Addr:            conf.Addr,
+		PoolSize:        conf.MaxIdleConn,
+		ConnMaxIdleTime: conf.IdleTimeout.Duration,
+		DialTimeout:     dialTimeout,
+		ReadTimeout:     dialTimeout,
+		WriteTimeout:    dialTimeout,
+	})
 }
 
+// DS is a cache.Conn backed by Redis. It serves hot swarm lookups
+// directly, and, when Backend is set, defers snatch-count writes to it
+// instead of applying them here. Transfer totals are not tracked or
+// forwarded by this driver.
 type DS struct {
-	conf *config.Storage
-	redis.Pool
+	conf   *config.Storage
+	client goredis.UniversalClient
+
+	// Backend drains changes for durable fields. If nil, DS falls back
+	// to applying them to Redis itself.
+	Backend backend.Conn
+	changes chan backend.Change
 }
 
-func (ds *DS) FindUser(passkey string) (*storage.User, bool, error) {
-	conn := ds.Get()
-	defer conn.Close()
+// drainChanges forwards durable-field deltas enqueued by the mutating DS
+// methods to Backend, or, if none is configured, applies them directly to
+// Redis so behavior is unchanged for deployments that don't run one.
+func (ds *DS) drainChanges() {
+	for change := range ds.changes {
+		ctx, cancel := context.WithTimeout(context.Background(), applyTimeout)
+		var err error
+		if ds.Backend != nil {
+			err = ds.Backend.Apply(ctx, change)
+		} else {
+			err = ds.applyChangeLocally(ctx, change)
+		}
+		cancel()
+		if err != nil {
+			log.Println("redis: failed to apply change", change, ":", err)
+		}
+	}
+}
 
+func (ds *DS) applyChangeLocally(ctx context.Context, change backend.Change) error {
+	switch change.Kind {
+	case "snatch":
+		torrentKey := ds.conf.Prefix + "Torrent:" + change.Infohash
+		userKey := ds.conf.Prefix + "User:" + change.Passkey
+
+		_, err := ds.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.HIncrBy(ctx, torrentKey, "Snatches", change.Delta)
+			pipe.HSet(ctx, torrentKey, "Completed", time.Now().Unix())
+			pipe.HIncrBy(ctx, userKey, "Snatches", change.Delta)
+			return nil
+		})
+		return err
+	}
+	return nil
+}
+
+func (ds *DS) FindUser(ctx context.Context, passkey string) (*storage.User, bool, error) {
 	key := ds.conf.Prefix + "User:" + passkey
-	reply, err := redis.Values(conn.Do("HGETALL", key))
+	cmd := ds.client.HGetAll(ctx, key)
+	fields, err := cmd.Result()
 	if err != nil {
 		return nil, true, err
 	}
 
 	// If we get nothing back, the user isn't found.
-	if len(reply) == 0 {
+	if len(fields) == 0 {
 		return nil, false, nil
 	}
 
 	user := &storage.User{}
-	err = redis.ScanStruct(reply, user)
-	if err != nil {
+	if err := cmd.Scan(user); err != nil {
 		return nil, true, err
 	}
 	return user, true, nil
 }
 
-func (ds *DS) FindTorrent(infohash string) (*storage.Torrent, bool, error) {
-	conn := ds.Get()
-	defer conn.Close()
-
+func (ds *DS) FindTorrent(ctx context.Context, infohash string) (*storage.Torrent, bool, error) {
 	key := ds.conf.Prefix + "Torrent:" + infohash
-	reply, err := redis.Values(conn.Do("HGETALL", key))
+	cmd := ds.client.HGetAll(ctx, key)
+	fields, err := cmd.Result()
 	if err != nil {
 		return nil, false, err
 	}
 
 	// If we get nothing back, the torrent isn't found.
-	if len(reply) == 0 {
+	if len(fields) == 0 {
 		return nil, false, nil
 	}
 
 	torrent := &storage.Torrent{}
-	err = redis.ScanStruct(reply, torrent)
-	if err != nil {
+	if err := cmd.Scan(torrent); err != nil {
 		return nil, true, err
 	}
 	return torrent, true, nil
 }
 
-func (ds *DS) ClientWhitelisted(peerID string) (bool, error) {
-	conn := ds.Get()
-	defer conn.Close()
+// reap periodically purges peers that haven't announced within the
+// configured inactivity window from every known torrent's swarms.
+func (ds *DS) reap() {
+	interval := reapInterval
+	if ds.conf.ReapInterval.Duration != 0 {
+		interval = ds.conf.ReapInterval.Duration
+	}
 
-	key := ds.conf.Prefix + "Whitelist:" + peerID
-	exists, err := redis.Bool(conn.Do("EXISTS", key))
-	if err != nil {
-		return false, err
+	for range time.Tick(interval) {
+		if err := ds.reapStalePeers(context.Background()); err != nil {
+			log.Println("redis: failed to reap stale peers:", err)
+		}
 	}
-	return exists, nil
 }
 
-type Tx struct {
-	conf *config.Storage
-	done bool
-	redis.Conn
+func (ds *DS) reapStalePeers(ctx context.Context) error {
+	infohashes, err := ds.client.SMembers(ctx, ds.conf.Prefix+"Torrents").Result()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-ds.conf.Inactivity.Duration).Unix()
+	for _, infohash := range infohashes {
+		for _, swarm := range []string{"leechers", "seeders"} {
+			if err := ds.reapSwarm(ctx, infohash, swarm, cutoff); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func (ds *DS) Begin() (storage.Tx, error) {
-	conn := ds.Get()
-	err := conn.Send("MULTI")
+// reapSwarm evicts peers stale as of cutoff from a single torrent's swarm.
+// It reads the stale set inside the WATCH transaction, so a peer that
+// re-announces between that read and the EXEC aborts and retries the reap
+// instead of being evicted anyway, and so the counter decrement always
+// matches the peers actually removed even if another client mutates the
+// swarm concurrently.
+func (ds *DS) reapSwarm(ctx context.Context, infohash, swarm string, cutoff int64) error {
+	swarmKey := ds.conf.Prefix + "Torrent:" + infohash + ":" + swarm
+	torrentKey := ds.conf.Prefix + "Torrent:" + infohash
+	counterField := "Leechers"
+	if swarm == "seeders" {
+		counterField = "Seeders"
+	}
+
+	var reaped []string
+	err := ds.withRetry(ctx, []string{torrentKey, swarmKey}, func(tx *goredis.Tx) error {
+		reaped = nil
+		stale, err := tx.ZRangeByScore(ctx, swarmKey, &goredis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(cutoff, 10),
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(stale) == 0 {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			for _, peerID := range stale {
+				pipe.ZRem(ctx, swarmKey, peerID)
+				pipe.Del(ctx, ds.conf.Prefix+"Peer:"+infohash+":"+peerID)
+			}
+			pipe.HIncrBy(ctx, torrentKey, counterField, int64(-len(stale)))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		reaped = stale
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &Tx{
-		conf: ds.conf,
-		Conn: conn,
-	}, nil
+
+	eventType := strings.TrimSuffix(swarm, "s") + "_remove"
+	for _, peerID := range reaped {
+		ds.publish(ctx, Event{Type: eventType, Infohash: infohash, PeerID: peerID})
+	}
+	return nil
 }
 
-func (tx *Tx) close() {
-	if tx.done {
-		panic("redis: transaction closed twice")
+func (ds *DS) ClientWhitelisted(ctx context.Context, peerID string) (bool, error) {
+	key := ds.conf.Prefix + "Whitelist:" + peerID
+	n, err := ds.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, err
 	}
-	tx.done = true
-	tx.Conn.Close()
+	return n > 0, nil
 }
 
-func (tx *Tx) Commit() error {
-	if tx.done {
-		return storage.ErrTxDone
+// PutUser creates or overwrites the user record at u.Passkey.
+func (ds *DS) PutUser(ctx context.Context, u *storage.User) error {
+	key := ds.conf.Prefix + "User:" + u.Passkey
+	return ds.client.HSet(ctx, key, u).Err()
+}
+
+// DeleteUser removes the user record identified by passkey.
+func (ds *DS) DeleteUser(ctx context.Context, passkey string) error {
+	key := ds.conf.Prefix + "User:" + passkey
+	return ds.client.Del(ctx, key).Err()
+}
+
+// PutTorrent creates or overwrites the torrent record at t.Infohash.
+func (ds *DS) PutTorrent(ctx context.Context, t *storage.Torrent) error {
+	key := ds.conf.Prefix + "Torrent:" + t.Infohash
+	return ds.client.HSet(ctx, key, t).Err()
+}
+
+// DeleteTorrent removes the torrent record and its swarms identified by
+// infohash.
+func (ds *DS) DeleteTorrent(ctx context.Context, infohash string) error {
+	_, err := ds.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Del(ctx, ds.conf.Prefix+"Torrent:"+infohash)
+		pipe.Del(ctx, ds.conf.Prefix+"Torrent:"+infohash+":leechers")
+		pipe.Del(ctx, ds.conf.Prefix+"Torrent:"+infohash+":seeders")
+		pipe.SRem(ctx, ds.conf.Prefix+"Torrents", infohash)
+		return nil
+	})
+	return err
+}
+
+// PutClient whitelists peerID.
+func (ds *DS) PutClient(ctx context.Context, peerID string) error {
+	key := ds.conf.Prefix + "Whitelist:" + peerID
+	return ds.client.Set(ctx, key, "", 0).Err()
+}
+
+// DeleteClient removes peerID from the whitelist.
+func (ds *DS) DeleteClient(ctx context.Context, peerID string) error {
+	key := ds.conf.Prefix + "Whitelist:" + peerID
+	return ds.client.Del(ctx, key).Err()
+}
+
+// withRetry runs fn inside a WATCH transaction over keys using go-redis's
+// optimistic-locking helper, retrying up to conf.TxRetries times when a
+// watched key changes out from under it. fn may read through tx (e.g. to
+// decide what to queue) before calling tx.TxPipelined to apply its
+// mutations atomically.
+func (ds *DS) withRetry(ctx context.Context, keys []string, fn func(tx *goredis.Tx) error) error {
+	var attempt int
+	for {
+		err := ds.client.Watch(ctx, fn, keys...)
+
+		if err != goredis.TxFailedErr {
+			return err
+		}
+
+		// Another client touched a watched key between WATCH and EXEC;
+		// retry from scratch.
+		attempt++
+		if attempt > ds.conf.TxRetries {
+			return storage.ErrTxRetriesExceeded
+		}
 	}
-	_, err := tx.Do("EXEC")
+}
+
+// Snatch records a completed download: it atomically moves peer from
+// torrent's leecher swarm to its seeder swarm, then enqueues a Change
+// that DS.Backend (or, absent one, DS itself) applies asynchronously to
+// bump the Snatches/Completed durable fields. The swarm move happens
+// inline, under the same WATCH-based retry as addPeer/rmPeer, so a
+// caller never observes peer counted as both a leecher and a seeder, or
+// as neither.
+func (ds *DS) Snatch(ctx context.Context, user *storage.User, torrent *storage.Torrent, peer *storage.Peer) error {
+	leechersKey := ds.conf.Prefix + "Torrent:" + torrent.Infohash + ":leechers"
+	seedersKey := ds.conf.Prefix + "Torrent:" + torrent.Infohash + ":seeders"
+	torrentKey := ds.conf.Prefix + "Torrent:" + torrent.Infohash
+
+	err := ds.withRetry(ctx, []string{torrentKey, leechersKey, seedersKey}, func(tx *goredis.Tx) error {
+		_, err := tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.ZRem(ctx, leechersKey, peer.ID)
+			pipe.ZAdd(ctx, seedersKey, goredis.Z{Score: float64(time.Now().Unix()), Member: peer.ID})
+			pipe.HIncrBy(ctx, torrentKey, "Leechers", -1)
+			pipe.HIncrBy(ctx, torrentKey, "Seeders", 1)
+			return nil
+		})
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
-	tx.close()
+	select {
+	case ds.changes <- backend.Change{
+		Kind:     "snatch",
+		Infohash: torrent.Infohash,
+		Passkey:  user.Passkey,
+		Delta:    1,
+	}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	ds.publish(ctx, Event{Type: "snatch", Infohash: torrent.Infohash, UserID: user.Passkey, PeerID: peer.ID, Delta: 1})
 	return nil
 }
 
-// Redis doesn't need to rollback. Exec is atomic.
-func (tx *Tx) Rollback() error {
-	if tx.done {
-		return storage.ErrTxDone
+func (ds *DS) Unprune(ctx context.Context, t *storage.Torrent) error {
+	key := ds.conf.Prefix + "Torrent:" + t.Infohash
+	err := ds.withRetry(ctx, []string{key}, func(tx *goredis.Tx) error {
+		_, err := tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.HSet(ctx, key, "Status", 0)
+			return nil
+		})
+		return err
+	})
+	if err == nil {
+		ds.publish(ctx, Event{Type: "unprune", Infohash: t.Infohash})
 	}
-	tx.close()
-	return nil
+	return err
 }
 
-func (tx *Tx) Snatch(user *storage.User, torrent *storage.Torrent) error {
-	if tx.done {
-		return storage.ErrTxDone
+func (ds *DS) NewLeecher(ctx context.Context, t *storage.Torrent, p *storage.Peer) error {
+	if err := ds.addPeer(ctx, t.Infohash, "leechers", p); err != nil {
+		return err
 	}
-	// TODO
+	ds.publish(ctx, Event{Type: "leecher_add", Infohash: t.Infohash, PeerID: p.ID})
 	return nil
 }
 
-func (tx *Tx) Unprune(t *storage.Torrent) error {
-	if tx.done {
-		return storage.ErrTxDone
-	}
-	key := tx.conf.Prefix + "Torrent:" + t.Infohash
-	err := tx.Send("HSET " + key + " Status 0")
-	if err != nil {
+func (ds *DS) RmLeecher(ctx context.Context, t *storage.Torrent, p *storage.Peer) error {
+	if err := ds.rmPeer(ctx, t.Infohash, "leechers", p); err != nil {
 		return err
 	}
+	ds.publish(ctx, Event{Type: "leecher_remove", Infohash: t.Infohash, PeerID: p.ID})
 	return nil
 }
 
-func (tx *Tx) NewLeecher(t *storage.Torrent, p *storage.Peer) error {
-	if tx.done {
-		return storage.ErrTxDone
+func (ds *DS) NewSeeder(ctx context.Context, t *storage.Torrent, p *storage.Peer) error {
+	if err := ds.addPeer(ctx, t.Infohash, "seeders", p); err != nil {
+		return err
 	}
-	// TODO
+	ds.publish(ctx, Event{Type: "seeder_add", Infohash: t.Infohash, PeerID: p.ID})
 	return nil
 }
 
-func (tx *Tx) RmLeecher(t *storage.Torrent, p *storage.Peer) error {
-	if tx.done {
-		return storage.ErrTxDone
+func (ds *DS) RmSeeder(ctx context.Context, t *storage.Torrent, p *storage.Peer) error {
+	if err := ds.rmPeer(ctx, t.Infohash, "seeders", p); err != nil {
+		return err
 	}
-	// TODO
+	ds.publish(ctx, Event{Type: "seeder_remove", Infohash: t.Infohash, PeerID: p.ID})
 	return nil
 }
 
-func (tx *Tx) NewSeeder(t *storage.Torrent, p *storage.Peer) error {
-	if tx.done {
-		return storage.ErrTxDone
+// addPeer adds p's ID to the given swarm's sorted set, scored by its last
+// announce time, and writes its body to a companion hash that expires
+// shortly after the peer is expected to announce again. The swarm's
+// counter only moves when p wasn't already a member, so re-announces
+// don't drift it upward.
+func (ds *DS) addPeer(ctx context.Context, infohash, swarm string, p *storage.Peer) error {
+	swarmKey := ds.conf.Prefix + "Torrent:" + infohash + ":" + swarm
+	peerKey := ds.conf.Prefix + "Peer:" + infohash + ":" + p.ID
+	torrentKey := ds.conf.Prefix + "Torrent:" + infohash
+	counterField := "Leechers"
+	if swarm == "seeders" {
+		counterField = "Seeders"
 	}
-	// TODO
-	return nil
+
+	return ds.withRetry(ctx, []string{torrentKey, swarmKey}, func(tx *goredis.Tx) error {
+		_, err := tx.ZScore(ctx, swarmKey, p.ID).Result()
+		alreadyInSwarm := err != goredis.Nil
+		if err != nil && err != goredis.Nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.SAdd(ctx, ds.conf.Prefix+"Torrents", infohash)
+			pipe.ZAdd(ctx, swarmKey, goredis.Z{Score: float64(time.Now().Unix()), Member: p.ID})
+			pipe.HSet(ctx, peerKey, p)
+			pipe.Expire(ctx, peerKey, peerTTL(ds.conf))
+			if !alreadyInSwarm {
+				pipe.HIncrBy(ctx, torrentKey, counterField, 1)
+			}
+			return nil
+		})
+		return err
+	})
 }
 
-func (tx *Tx) RmSeeder(t *storage.Torrent, p *storage.Peer) error {
-	if tx.done {
-		return storage.ErrTxDone
+func (ds *DS) rmPeer(ctx context.Context, infohash, swarm string, p *storage.Peer) error {
+	swarmKey := ds.conf.Prefix + "Torrent:" + infohash + ":" + swarm
+	peerKey := ds.conf.Prefix + "Peer:" + infohash + ":" + p.ID
+	torrentKey := ds.conf.Prefix + "Torrent:" + infohash
+	counterField := "Leechers"
+	if swarm == "seeders" {
+		counterField = "Seeders"
 	}
-	// TODO
-	return nil
+
+	return ds.withRetry(ctx, []string{torrentKey, swarmKey}, func(tx *goredis.Tx) error {
+		_, err := tx.ZScore(ctx, swarmKey, p.ID).Result()
+		if err == goredis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.ZRem(ctx, swarmKey, p.ID)
+			pipe.Del(ctx, peerKey)
+			pipe.HIncrBy(ctx, torrentKey, counterField, -1)
+			return nil
+		})
+		return err
+	})
 }
 
-func (tx *Tx) IncrementSlots(u *storage.User) error {
-	if tx.done {
-		return storage.ErrTxDone
+// peerTTL returns how long a peer's companion hash should live before
+// expiring, a little longer than the tracker's announce interval so a
+// peer that announces on schedule never has its body evicted out from
+// under it.
+func peerTTL(conf *config.Storage) time.Duration {
+	if conf.AnnounceInterval.Duration == 0 {
+		return reapInterval * 2
 	}
-	// TODO
-	return nil
+	return conf.AnnounceInterval.Duration + conf.AnnounceInterval.Duration/2
 }
 
-func (tx *Tx) DecrementSlots(u *storage.User) error {
-	if tx.done {
-		return storage.ErrTxDone
+func (ds *DS) IncrementSlots(ctx context.Context, u *storage.User) error {
+	key := ds.conf.Prefix + "User:" + u.Passkey
+	err := ds.withRetry(ctx, []string{key}, func(tx *goredis.Tx) error {
+		_, err := tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.HIncrBy(ctx, key, "Slots", 1)
+			return nil
+		})
+		return err
+	})
+	if err == nil {
+		ds.publish(ctx, Event{Type: "slots", UserID: u.Passkey, Delta: 1})
 	}
-	// TODO
-	return nil
+	return err
+}
+
+func (ds *DS) DecrementSlots(ctx context.Context, u *storage.User) error {
+	key := ds.conf.Prefix + "User:" + u.Passkey
+	err := ds.withRetry(ctx, []string{key}, func(tx *goredis.Tx) error {
+		_, err := tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.HIncrBy(ctx, key, "Slots", -1)
+			return nil
+		})
+		return err
+	})
+	if err == nil {
+		ds.publish(ctx, Event{Type: "slots", UserID: u.Passkey, Delta: -1})
+	}
+	return err
 }
 
 func init() {
-	storage.Register("redis", &driver{})
+	cache.Register("redis", &driver{})
 }
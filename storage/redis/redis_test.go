@@ -0,0 +1,145 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+// +build redis
+
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/pushrax/chihaya/config"
+	"github.com/pushrax/chihaya/storage"
+	"github.com/pushrax/chihaya/storage/storagetest"
+)
+
+// These tests talk to a real Redis instance on localhost:6379 and are only
+// built when the "redis" build tag is set, since they require external
+// infrastructure that CI doesn't provide by default.
+
+func testConf() *config.Storage {
+	return &config.Storage{
+		Network: "tcp",
+		Addr:    "127.0.0.1:6379",
+		Prefix:  "test:",
+	}
+}
+
+func newDS(t *testing.T, conf *config.Storage) *DS {
+	d := &driver{}
+	return d.New(conf).(*DS)
+}
+
+func flushPrefix(t *testing.T, conf *config.Storage) {
+	ctx := context.Background()
+	client := goredis.NewClient(&goredis.Options{Network: conf.Network, Addr: conf.Addr})
+	defer client.Close()
+
+	keys, err := client.Keys(ctx, conf.Prefix+"*").Result()
+	if err != nil {
+		t.Skip("redis not available:", err)
+	}
+	if len(keys) > 0 {
+		client.Del(ctx, keys...)
+	}
+}
+
+func TestNewLeecherThenSeeder(t *testing.T) {
+	conf := testConf()
+	flushPrefix(t, conf)
+	ctx := context.Background()
+
+	ds := newDS(t, conf)
+	torrent := &storage.Torrent{Infohash: "abc"}
+	peer := &storage.Peer{ID: "peer1"}
+
+	if err := ds.NewLeecher(ctx, torrent, peer); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.RmLeecher(ctx, torrent, peer); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.NewSeeder(ctx, torrent, peer); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReapStalePeers checks that a peer past the configured inactivity
+// window is evicted from its swarm and the torrent's counter follows it
+// down, exercising the same WATCH-based retry path as addPeer/rmPeer.
+func TestReapStalePeers(t *testing.T) {
+	conf := testConf()
+	conf.Inactivity = config.Duration{Duration: time.Minute}
+	flushPrefix(t, conf)
+	ctx := context.Background()
+
+	ds := newDS(t, conf)
+	torrent := &storage.Torrent{Infohash: "stale"}
+	peer := &storage.Peer{ID: "peer1"}
+
+	if err := ds.NewLeecher(ctx, torrent, peer); err != nil {
+		t.Fatal(err)
+	}
+
+	// Backdate the peer's announce score so it falls outside the
+	// inactivity window without waiting for real time to pass.
+	swarmKey := conf.Prefix + "Torrent:" + torrent.Infohash + ":leechers"
+	stale := time.Now().Add(-2 * time.Minute).Unix()
+	if err := ds.client.ZAdd(ctx, swarmKey, goredis.Z{Score: float64(stale), Member: peer.ID}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.reapStalePeers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := ds.FindTorrent(ctx, torrent.Infohash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Leechers != 0 {
+		t.Fatalf("after reap, Leechers = %d, want 0", got.Leechers)
+	}
+	if score, err := ds.client.ZScore(ctx, swarmKey, peer.ID).Result(); err != goredis.Nil {
+		t.Fatalf("after reap, peer still in swarm with score %v (err %v)", score, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	conf := testConf()
+	// The suite's ConcurrentSlots case contends on one user's slot
+	// counter, so the WATCH transactions need room to retry.
+	conf.TxRetries = 10
+	flushPrefix(t, conf)
+
+	ds := newDS(t, conf)
+	storagetest.Suite(t, ds, ds)
+}
+
+// TestConcurrentSlotChanges exercises the WATCH-based retry loop by
+// hammering the same user's slot counter from many goroutines at once.
+func TestConcurrentSlotChanges(t *testing.T) {
+	conf := testConf()
+	conf.TxRetries = 10
+	flushPrefix(t, conf)
+	ctx := context.Background()
+
+	ds := newDS(t, conf)
+	user := &storage.User{Passkey: "user1"}
+
+	const n = 50
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() { errs <- ds.IncrementSlots(ctx, user) }()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
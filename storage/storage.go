@@ -0,0 +1,48 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+// Package storage defines the data types shared by every storage driver
+// (see storage/cache and storage/backend), so that cache and backend
+// implementations speak the same vocabulary of users, torrents, and
+// peers regardless of what they're backed by.
+package storage
+
+import "errors"
+
+// ErrTxRetriesExceeded is returned by a cache driver's mutating methods
+// when an optimistic-concurrency transaction couldn't complete within
+// its configured retry budget.
+var ErrTxRetriesExceeded = errors.New("storage: exceeded transaction retries")
+
+// User is a tracker account, keyed by Passkey.
+type User struct {
+	Passkey  string `redis:"Passkey"`
+	Snatches int64  `redis:"Snatches"`
+	Slots    int64  `redis:"Slots"`
+}
+
+// Torrent is a tracker's view of a single swarm, keyed by Infohash.
+// Leechers and Seeders are maintained by a cache driver's NewLeecher,
+// RmLeecher, NewSeeder, and RmSeeder methods rather than derived from the
+// swarm membership on every read.
+type Torrent struct {
+	Infohash  string `redis:"Infohash"`
+	Status    int    `redis:"Status"`
+	Snatches  int64  `redis:"Snatches"`
+	Completed int64  `redis:"Completed"`
+	Leechers  int    `redis:"Leechers"`
+	Seeders   int    `redis:"Seeders"`
+}
+
+// Peer is a single client announcing for a torrent.
+type Peer struct {
+	ID         string `redis:"ID"`
+	UserID     string `redis:"UserID"`
+	IP         string `redis:"IP"`
+	Port       uint16 `redis:"Port"`
+	Uploaded   int64  `redis:"Uploaded"`
+	Downloaded int64  `redis:"Downloaded"`
+	Left       int64  `redis:"Left"`
+	StartTime  int64  `redis:"StartTime"`
+}
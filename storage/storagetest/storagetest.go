@@ -0,0 +1,210 @@
+// Copyright 2013 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+// Package storagetest is a conformance suite that any cache.Conn
+// implementation can run against to make sure it behaves the way the
+// tracker expects, so that drivers don't drift apart from one another.
+package storagetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pushrax/chihaya/storage"
+	"github.com/pushrax/chihaya/storage/cache"
+)
+
+// Suite exercises find/put, snatch recording, peer add/remove, unprune,
+// slot inc/dec, and concurrency on conn, using admin to seed the records
+// it works on. Deletion is not exercised. conn must start out empty.
+func Suite(t *testing.T, conn cache.Conn, admin interface {
+	PutUser(ctx context.Context, u *storage.User) error
+	PutTorrent(ctx context.Context, t *storage.Torrent) error
+	PutClient(ctx context.Context, peerID string) error
+}) {
+	ctx := context.Background()
+
+	t.Run("FindMissing", func(t *testing.T) {
+		if _, found, err := conn.FindUser(ctx, "nope"); err != nil || found {
+			t.Fatalf("FindUser(missing) = found %v, err %v", found, err)
+		}
+		if _, found, err := conn.FindTorrent(ctx, "nope"); err != nil || found {
+			t.Fatalf("FindTorrent(missing) = found %v, err %v", found, err)
+		}
+		if whitelisted, err := conn.ClientWhitelisted(ctx, "nope"); err != nil || whitelisted {
+			t.Fatalf("ClientWhitelisted(missing) = %v, err %v", whitelisted, err)
+		}
+	})
+
+	user := &storage.User{Passkey: "passkey1"}
+	torrent := &storage.Torrent{Infohash: "infohash1"}
+	peer := &storage.Peer{ID: "peer1"}
+
+	if err := admin.PutUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.PutTorrent(ctx, torrent); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.PutClient(ctx, peer.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("FindAfterPut", func(t *testing.T) {
+		if _, found, err := conn.FindUser(ctx, user.Passkey); err != nil || !found {
+			t.Fatalf("FindUser(%q) = found %v, err %v", user.Passkey, found, err)
+		}
+		if _, found, err := conn.FindTorrent(ctx, torrent.Infohash); err != nil || !found {
+			t.Fatalf("FindTorrent(%q) = found %v, err %v", torrent.Infohash, found, err)
+		}
+		if whitelisted, err := conn.ClientWhitelisted(ctx, peer.ID); err != nil || !whitelisted {
+			t.Fatalf("ClientWhitelisted(%q) = %v, err %v", peer.ID, whitelisted, err)
+		}
+	})
+
+	t.Run("PeerLifecycle", func(t *testing.T) {
+		if err := conn.NewLeecher(ctx, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _ := conn.FindTorrent(ctx, torrent.Infohash); got.Leechers != 1 {
+			t.Fatalf("after NewLeecher, Leechers = %d, want 1", got.Leechers)
+		}
+
+		if err := conn.RmLeecher(ctx, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _ := conn.FindTorrent(ctx, torrent.Infohash); got.Leechers != 0 {
+			t.Fatalf("after RmLeecher, Leechers = %d, want 0", got.Leechers)
+		}
+
+		if err := conn.NewSeeder(ctx, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _ := conn.FindTorrent(ctx, torrent.Infohash); got.Seeders != 1 {
+			t.Fatalf("after NewSeeder, Seeders = %d, want 1", got.Seeders)
+		}
+
+		if err := conn.RmSeeder(ctx, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _ := conn.FindTorrent(ctx, torrent.Infohash); got.Seeders != 0 {
+			t.Fatalf("after RmSeeder, Seeders = %d, want 0", got.Seeders)
+		}
+
+		// Re-adding the same peer must not double-count it.
+		if err := conn.NewLeecher(ctx, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.NewLeecher(ctx, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _ := conn.FindTorrent(ctx, torrent.Infohash); got.Leechers != 1 {
+			t.Fatalf("after duplicate NewLeecher, Leechers = %d, want 1", got.Leechers)
+		}
+		if err := conn.RmLeecher(ctx, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Snatch", func(t *testing.T) {
+		if err := conn.NewLeecher(ctx, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+		before, _, _ := conn.FindTorrent(ctx, torrent.Infohash)
+
+		if err := conn.Snatch(ctx, user, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _ := conn.FindTorrent(ctx, torrent.Infohash); got.Leechers != before.Leechers-1 {
+			t.Fatalf("after Snatch, Leechers = %d, want %d", got.Leechers, before.Leechers-1)
+		} else if got.Seeders != before.Seeders+1 {
+			t.Fatalf("after Snatch, Seeders = %d, want %d", got.Seeders, before.Seeders+1)
+		}
+
+		// Some drivers apply durable fields like Snatches asynchronously
+		// (e.g. via a backend change queue), so give it a moment to land
+		// rather than assuming it's visible the instant Snatch returns.
+		after, ok := awaitTorrentCondition(conn, torrent.Infohash, func(t *storage.Torrent) bool {
+			return t.Snatches == before.Snatches+1
+		})
+		if !ok {
+			t.Fatalf("after Snatch, Snatches = %d, want %d", after.Snatches, before.Snatches+1)
+		}
+
+		if err := conn.RmSeeder(ctx, torrent, peer); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Unprune", func(t *testing.T) {
+		if err := conn.Unprune(ctx, torrent); err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _ := conn.FindTorrent(ctx, torrent.Infohash); got.Status != 0 {
+			t.Fatalf("after Unprune, Status = %d, want 0", got.Status)
+		}
+	})
+
+	t.Run("ConcurrentSlots", func(t *testing.T) {
+		before, _, _ := conn.FindUser(ctx, user.Passkey)
+
+		const n = 50
+		var wg sync.WaitGroup
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func() {
+				defer wg.Done()
+				if err := conn.IncrementSlots(ctx, user); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got, _, _ := conn.FindUser(ctx, user.Passkey); got.Slots != before.Slots+n {
+			t.Fatalf("after %d IncrementSlots, Slots = %d, want %d", n, got.Slots, before.Slots+n)
+		}
+
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func() {
+				defer wg.Done()
+				if err := conn.DecrementSlots(ctx, user); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got, _, _ := conn.FindUser(ctx, user.Passkey); got.Slots != before.Slots {
+			t.Fatalf("after %d DecrementSlots, Slots = %d, want %d", n, got.Slots, before.Slots)
+		}
+	})
+}
+
+// awaitTorrentCondition polls FindTorrent until cond is satisfied or a
+// short deadline passes, returning the last torrent it saw and whether
+// cond held. It exists because some drivers apply durable fields
+// asynchronously, so a mutation's effects aren't guaranteed visible the
+// instant the call that triggered them returns.
+func awaitTorrentCondition(conn cache.Conn, infohash string, cond func(*storage.Torrent) bool) (*storage.Torrent, bool) {
+	ctx := context.Background()
+	deadline := time.Now().Add(time.Second)
+	var last *storage.Torrent
+	for {
+		t, _, err := conn.FindTorrent(ctx, infohash)
+		if err == nil {
+			last = t
+			if cond(t) {
+				return t, true
+			}
+		}
+		if time.Now().After(deadline) {
+			return last, false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}